@@ -0,0 +1,82 @@
+package decoder
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+	"github.com/ailumiyana/goav-incr/goav/swscale"
+)
+
+// bufferKey identifies a class of converter output buffers that can be
+// reused across frames: same dimensions and pixel format always need the
+// same buffer size.
+type bufferKey struct {
+	w, h   int
+	pixFmt swscale.PixelFormat
+}
+
+// maxPooledBuffersPerKey bounds how many idle av_malloc'd buffers are kept
+// per bufferKey. sync.Pool can't be used here: it documents that pooled
+// items "may be removed automatically at any time without notification",
+// which for ordinary Go values just means an extra allocation later, but
+// for native av_malloc'd memory means a silent, permanent leak, since
+// nothing else holds a reference to av_free it. A bounded channel-backed
+// free list gives the same reuse with eviction we control.
+const maxPooledBuffersPerKey = 4
+
+type bufferPool struct {
+	free chan unsafe.Pointer
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = map[bufferKey]*bufferPool{}
+)
+
+// getBuffer returns a size-byte av_malloc'd buffer for key, reusing one from
+// the free list when available instead of hitting av_malloc on every frame.
+func getBuffer(key bufferKey, size int) unsafe.Pointer {
+	pool := getOrCreatePool(key)
+
+	select {
+	case buf := <-pool.free:
+		return buf
+	default:
+		return avutil.AvMalloc(uintptr(size))
+	}
+}
+
+// putBuffer returns buf to key's free list so a future frame of the same
+// size and pixel format can reuse it. If the free list is already full, buf
+// is av_free'd immediately rather than handed to a pool that might drop it
+// without freeing it.
+func putBuffer(key bufferKey, buf unsafe.Pointer) {
+	poolsMu.Lock()
+	pool := pools[key]
+	poolsMu.Unlock()
+
+	if pool == nil {
+		avutil.AvFree(buf)
+		return
+	}
+
+	select {
+	case pool.free <- buf:
+	default:
+		avutil.AvFree(buf)
+	}
+}
+
+func getOrCreatePool(key bufferKey) *bufferPool {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	pool, ok := pools[key]
+	if !ok {
+		pool = &bufferPool{free: make(chan unsafe.Pointer, maxPooledBuffersPerKey)}
+		pools[key] = pool
+	}
+
+	return pool
+}
@@ -0,0 +1,45 @@
+package decoder
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/swscale"
+)
+
+// TestBufferPoolReusesBuffer checks that a buffer handed back via putBuffer
+// is the same one a later getBuffer for the same key returns, instead of a
+// fresh av_malloc.
+func TestBufferPoolReusesBuffer(t *testing.T) {
+	key := bufferKey{w: 640, h: 480, pixFmt: swscale.PixelFormat(0)}
+
+	buf := getBuffer(key, 1024)
+	putBuffer(key, buf)
+
+	got := getBuffer(key, 1024)
+	if got != buf {
+		t.Fatalf("expected getBuffer to reuse the pooled buffer, got a different pointer")
+	}
+
+	putBuffer(key, got)
+}
+
+// TestBufferPoolBoundedSize checks that the free list never holds more than
+// maxPooledBuffersPerKey buffers: anything beyond that must be av_free'd
+// immediately rather than handed to an unbounded pool.
+func TestBufferPoolBoundedSize(t *testing.T) {
+	key := bufferKey{w: 1280, h: 720, pixFmt: swscale.PixelFormat(1)}
+
+	bufs := make([]unsafe.Pointer, 0, maxPooledBuffersPerKey+2)
+	for i := 0; i < maxPooledBuffersPerKey+2; i++ {
+		bufs = append(bufs, getBuffer(key, 4096))
+	}
+	for _, buf := range bufs {
+		putBuffer(key, buf)
+	}
+
+	pool := getOrCreatePool(key)
+	if len(pool.free) != maxPooledBuffersPerKey {
+		t.Fatalf("expected free list to cap at %d buffers, got %d", maxPooledBuffersPerKey, len(pool.free))
+	}
+}
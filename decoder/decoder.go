@@ -8,6 +8,10 @@ import (
 	"github.com/ailumiyana/goav-incr/goav/avutil"
 	"github.com/ailumiyana/goav-incr/goav/swscale"
 	"image"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -34,18 +38,65 @@ type Decoder struct {
 	frame     *avutil.Frame
 	pkt       *avcodec.Packet
 	converter *converter
+
+	hwAccelMode HWAccelMode
+	hwDeviceCtx *avutil.BufferRef
+
+	streamErrMu sync.Mutex
+	streamErr   error
 }
 
+// streamBufferSize is the chunk size DecodeStream/Frames read from the
+// source io.Reader.
+const streamBufferSize = 2048
+
 // Frame represents decoded frame from H.264/H.265 stream
 // Data field will contain bitmap data in the pixel format specified in the decoder
 type Frame struct {
 	Data                  []byte
 	Width, Height, Stride int
+
+	// PTS and DTS are the presentation/decode timestamps, echoed back from
+	// the pts argument passed to Decode. DecodeStream and Frames have no
+	// per-chunk timestamp input, so frames they emit always report
+	// avcodec.AV_NOPTS_VALUE; use Decode directly when real timestamps
+	// matter.
+	PTS, DTS int64
+
+	// KeyFrame is true for I-frames, which a consumer can use as a safe
+	// point to resume or drop frames under backpressure.
+	KeyFrame bool
+
+	// PictureType is the frame's slice type (I/P/B).
+	PictureType PictureType
+
+	bufKey   bufferKey
+	buf      unsafe.Pointer
+	released int32
+}
+
+// Release returns the buffer backing Data to the converter's pool. Call it
+// once you're done reading Data; Data must not be used afterwards. If
+// Release is never called, a finalizer reclaims the buffer eventually, but
+// don't rely on that in latency-sensitive pipelines.
+func (f *Frame) Release() {
+	if !atomic.CompareAndSwapInt32(&f.released, 0, 1) {
+		return
+	}
+	runtime.SetFinalizer(f, nil)
+	putBuffer(f.bufKey, f.buf)
 }
 
 // New creates new Decoder
 // It accepts expected pixel format for the output which
-func New(pxlFmt PixelFormat, cpr Compression) (*Decoder, error) {
+// Pass WithHWAccel to offload decoding to a GPU; if the hardware device
+// cannot be created, New transparently falls back to software decoding.
+func New(pxlFmt PixelFormat, cpr Compression, opts ...Option) (*Decoder, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
 	avcodec.AvcodecRegisterAll()
 	codec := avcodec.AvcodecFindDecoder(avcodec.CodecId(cpr))
 	if codec == nil {
@@ -56,8 +107,40 @@ func New(pxlFmt PixelFormat, cpr Compression) (*Decoder, error) {
 		return nil, errors.New("cannot allocate context")
 	}
 
+	var hwDeviceCtx *avutil.BufferRef
+	hwAccelMode := HWAccelNone
+	if o.hwAccelMode != HWAccelNone {
+		if ctx, ok := setupHWAccel(context, o.hwAccelMode, o.hwDevice); ok {
+			hwDeviceCtx = ctx
+			hwAccelMode = o.hwAccelMode
+		}
+	}
+
 	if context.AvcodecOpen2(codec, nil) < 0 {
-		return nil, errors.New("cannot open content")
+		if hwAccelMode == HWAccelNone {
+			avutil.AvFree(unsafe.Pointer(context))
+			return nil, errors.New("cannot open content")
+		}
+
+		// the hw device was created but the decoder refused to negotiate
+		// its pixel format (e.g. unsupported profile); fall back to a
+		// plain software open instead of failing outright. Reopening the
+		// same AVCodecContext after a failed avcodec_open2 isn't a
+		// supported pattern, so the failed context is torn down and a
+		// fresh one takes its place.
+		teardownHWAccel(context, hwDeviceCtx)
+		avutil.AvFree(unsafe.Pointer(context))
+		hwDeviceCtx = nil
+		hwAccelMode = HWAccelNone
+
+		context = codec.AvcodecAllocContext3()
+		if context == nil {
+			return nil, errors.New("cannot allocate context")
+		}
+		if context.AvcodecOpen2(codec, nil) < 0 {
+			avutil.AvFree(unsafe.Pointer(context))
+			return nil, errors.New("cannot open content")
+		}
 	}
 	parser := avcodec.AvParserInit(int(cpr))
 	if parser == nil {
@@ -94,36 +177,117 @@ func New(pxlFmt PixelFormat, cpr Compression) (*Decoder, error) {
 	}
 
 	h := &Decoder{
-		context:   context,
-		parser:    parser,
-		frame:     frame,
-		pkt:       pkt,
-		converter: converter,
+		context:     context,
+		parser:      parser,
+		frame:       frame,
+		pkt:         pkt,
+		converter:   converter,
+		hwAccelMode: hwAccelMode,
+		hwDeviceCtx: hwDeviceCtx,
 	}
 
 	return h, nil
 }
 
-// Decode tries to parse the input data and return list of frames
-// If input data doesn't contain any H.264/H.265 frames the list will be empty
-func (h *Decoder) Decode(data []byte) ([]*Frame, error) {
+// Decode tries to parse data, a single chunk/packet of the bitstream taken
+// at pts (its presentation timestamp, in whatever units the caller is
+// tracking; pass avcodec.AV_NOPTS_VALUE if none is available), and returns
+// the list of frames it yields. If data doesn't contain any H.264/H.265
+// frames the list will be empty.
+func (h *Decoder) Decode(data []byte, pts int64) ([]*Frame, error) {
 	var frames []*Frame
 
+	err := h.decodeChunk(data, pts, func(frame *Frame) error {
+		frames = append(frames, frame)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+// DecodeStream reads raw H.264/H.265 bitstream data from r and invokes cb for
+// each frame as soon as it is decoded, instead of buffering every frame from
+// a call into a slice. This keeps memory flat for long-running RTSP/live
+// pipelines. Decoding stops and DecodeStream returns the error, if cb returns
+// a non-nil error.
+//
+// DecodeStream has no way to learn a timestamp for the chunks it reads off
+// r, so every Frame it produces reports avcodec.AV_NOPTS_VALUE for PTS/DTS;
+// call Decode directly, chunk by chunk, if you need real timestamps.
+func (h *Decoder) DecodeStream(r io.Reader, cb func(*Frame) error) error {
+	buf := make([]byte, streamBufferSize)
+
+	for {
+		nread, rerr := r.Read(buf)
+
+		if nread > 0 {
+			if err := h.decodeChunk(buf[:nread], avcodec.AV_NOPTS_VALUE, cb); err != nil {
+				return err
+			}
+		}
+
+		if rerr != nil {
+			if rerr == io.EOF {
+				return nil
+			}
+			return rerr
+		}
+	}
+}
+
+// Frames starts decoding r in a background goroutine and streams decoded
+// frames on the returned channel. The channel is closed once r is exhausted
+// or decoding fails; call Err to find out whether it stopped because of an
+// error.
+func (h *Decoder) Frames(r io.Reader) <-chan *Frame {
+	out := make(chan *Frame)
+
+	go func() {
+		defer close(out)
+		err := h.DecodeStream(r, func(frame *Frame) error {
+			out <- frame
+			return nil
+		})
+		h.streamErrMu.Lock()
+		h.streamErr = err
+		h.streamErrMu.Unlock()
+	}()
+
+	return out
+}
+
+// Err returns the error that stopped the last Frames channel, if any. It is
+// safe to call concurrently with the channel still being drained.
+func (h *Decoder) Err() error {
+	h.streamErrMu.Lock()
+	defer h.streamErrMu.Unlock()
+	return h.streamErr
+}
+
+// decodeChunk parses data, which may contain zero, one, or several frames,
+// and invokes cb for every frame it decodes. pts is attributed to every
+// frame decoded out of data.
+func (h *Decoder) decodeChunk(data []byte, pts int64, cb func(*Frame) error) error {
 	for len(data) > 0 {
-		frame, nread, isFrameAvailable, err := h.decodeFrameImpl(data)
+		frame, nread, isFrameAvailable, err := h.decodeFrameImpl(data, pts)
 
 		if err != nil && nread < 0 {
-			return nil, err
+			return err
 		}
 
 		if isFrameAvailable && frame != nil {
-			frames = append(frames, frame)
+			if err := cb(frame); err != nil {
+				return err
+			}
 		}
 
 		data = data[nread:]
 	}
 
-	return frames, nil
+	return nil
 }
 
 // Close free ups memory used for decoder structures
@@ -131,11 +295,21 @@ func (h *Decoder) Decode(data []byte) ([]*Frame, error) {
 func (h *Decoder) Close() {
 	h.converter.Close()
 
+	if h.hwDeviceCtx != nil {
+		hwPixFmtsMu.Lock()
+		delete(hwPixFmts, uintptr(unsafe.Pointer(h.context)))
+		hwPixFmtsMu.Unlock()
+	}
+
 	avcodec.AvParserClose(h.parser)
 	h.context.AvcodecClose()
 	avutil.AvFree(unsafe.Pointer(h.context))
 	avutil.AvFrameFree(h.frame)
 	h.pkt.AvFreePacket()
+
+	if h.hwDeviceCtx != nil {
+		avutil.AvBufferUnref(h.hwDeviceCtx)
+	}
 }
 
 // ToRGBA converts the frame into image.RGBA
@@ -149,13 +323,13 @@ func (f *Frame) ToRGB() *rgb.Image {
 	}
 }
 
-func (h *Decoder) parse(data []byte, bs int) int {
+func (h *Decoder) parse(data []byte, bs int, pts int64) int {
 	return h.context.AvParserParse2(
 		h.parser,
 		h.pkt,
 		data,
 		bs,
-		0, 0, avcodec.AV_NOPTS_VALUE,
+		pts, pts, avcodec.AV_NOPTS_VALUE,
 	)
 }
 
@@ -173,9 +347,9 @@ func (h *Decoder) decodeFrame() (*avutil.Frame, error) {
 	return h.frame, nil
 }
 
-func (h *Decoder) decodeFrameImpl(data []byte) (*Frame, int, bool, error) {
+func (h *Decoder) decodeFrameImpl(data []byte, pts int64) (*Frame, int, bool, error) {
 	size := len(data)
-	nread := h.parse(data, size)
+	nread := h.parse(data, size, pts)
 
 	if !h.isFrameAvailable() {
 		return nil, nread, false, nil
@@ -186,35 +360,54 @@ func (h *Decoder) decodeFrameImpl(data []byte) (*Frame, int, bool, error) {
 		return nil, nread, true, err
 	}
 
+	if h.hwAccelMode != HWAccelNone && isHWFrame(frame, h.hwAccelMode) {
+		swFrame, err := transferHWFrame(frame)
+		if err != nil {
+			return nil, nread, true, err
+		}
+		defer avutil.AvFrameFree(swFrame)
+		frame = swFrame
+	}
+
 	width, height := h.context.Width(), h.context.Height()
-	bufferSize := uintptr(h.converter.PredictSize(width, height))
-	buffer := (*uint8)(avutil.AvMalloc(bufferSize))
-	defer avutil.AvFree(unsafe.Pointer(buffer))
+	bufferSize := h.converter.PredictSize(width, height)
+	key := bufferKey{w: width, h: height, pixFmt: h.converter.pixFmt}
+	buffer := (*uint8)(getBuffer(key, bufferSize))
 	rgbframe, err := h.converter.Convert(h.context, frame, buffer)
 
 	if err != nil {
+		putBuffer(key, unsafe.Pointer(buffer))
 		return nil, nread, true, err
 	}
 
-	return newFrame(rgbframe), nread, true, nil
+	return newFrame(frame, rgbframe, unsafe.Pointer(buffer), key, h.pkt.Pts(), h.pkt.Dts()), nread, true, nil
 }
 
-func newFrame(frame *avutil.Frame) *Frame {
-	w, h, linesize := frame.Width(), frame.Height(), avutil.Linesize(frame)
-
-	return &Frame{
-		Data:   frameData(frame),
-		Width:  w,
-		Height: h,
-		Stride: int(linesize[0]),
+func newFrame(decoded, rgb *avutil.Frame, buf unsafe.Pointer, key bufferKey, pts, dts int64) *Frame {
+	w, hgt, linesize := rgb.Width(), rgb.Height(), avutil.Linesize(rgb)
+
+	f := &Frame{
+		Data:        frameData(buf, int(linesize[0])*hgt),
+		Width:       w,
+		Height:      hgt,
+		Stride:      int(linesize[0]),
+		PTS:         pts,
+		DTS:         dts,
+		KeyFrame:    decoded.KeyFrame() != 0,
+		PictureType: newPictureType(decoded.PictType()),
+		bufKey:      key,
+		buf:         buf,
 	}
-}
 
-func frameData(frame *avutil.Frame) []byte {
-	h, linesize, data := frame.Height(), avutil.Linesize(frame), avutil.Data(frame)
-	size := int(linesize[0]) * h
+	runtime.SetFinalizer(f, (*Frame).Release)
+
+	return f
+}
 
-	return C.GoBytes(unsafe.Pointer(data[0]), C.int(size))
+// frameData wraps buf with a zero-copy slice instead of copying it with
+// C.GoBytes; the caller owns buf until Frame.Release returns it to the pool.
+func frameData(buf unsafe.Pointer, size int) []byte {
+	return unsafe.Slice((*byte)(buf), size)
 }
 
 type converter struct {
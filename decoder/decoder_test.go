@@ -18,13 +18,14 @@ func BenchmarkDecoder(b *testing.B) {
 	}
 
 	for i := 0; i < b.N; i++ {
-		d, err := decoder.New(decoder.PixelFormatRGB)
+		d, err := decoder.New(decoder.PixelFormatRGB, decoder.H264)
 		if err != nil {
 			panic(err)
 		}
 
 		buf := make([]byte, 2048)
 		offset := int64(0)
+		pts := int64(0)
 
 		for {
 			nread, err := in.ReadAt(buf, offset)
@@ -38,7 +39,8 @@ func BenchmarkDecoder(b *testing.B) {
 				}
 			}
 
-			_, err = d.Decode(buf[:nread])
+			_, err = d.Decode(buf[:nread], pts)
+			pts++
 			if err != nil {
 				b.Error(err)
 			}
@@ -49,7 +51,7 @@ func BenchmarkDecoder(b *testing.B) {
 }
 
 func TestDecoder(t *testing.T) {
-	d, err := decoder.New(decoder.PixelFormatBGR)
+	d, err := decoder.New(decoder.PixelFormatBGR, decoder.H264)
 	if err != nil {
 		panic(err)
 	}
@@ -62,6 +64,7 @@ func TestDecoder(t *testing.T) {
 	window := gocv.NewWindow("H.264 decoder")
 
 	buf := make([]byte, 2048)
+	pts := int64(0)
 
 	for {
 		nread, err := stream.Read(buf)
@@ -73,7 +76,8 @@ func TestDecoder(t *testing.T) {
 				t.Error(err)
 			}
 		}
-		frames, err := d.Decode(buf[:nread])
+		frames, err := d.Decode(buf[:nread], pts)
+		pts++
 		if err != nil {
 			t.Error(err)
 		}
@@ -96,7 +100,7 @@ func TestDecoder(t *testing.T) {
 }
 
 func TestDecoderImage(t *testing.T) {
-	d, err := decoder.New(decoder.PixelFormatRGB)
+	d, err := decoder.New(decoder.PixelFormatRGB, decoder.H264)
 	if err != nil {
 		panic(err)
 	}
@@ -108,6 +112,7 @@ func TestDecoderImage(t *testing.T) {
 
 	buf := make([]byte, 2048)
 	frameCounter := 0
+	pts := int64(0)
 
 	for {
 		nread, err := stream.Read(buf)
@@ -119,7 +124,8 @@ func TestDecoderImage(t *testing.T) {
 				t.Error(err)
 			}
 		}
-		frames, err := d.Decode(buf[:nread])
+		frames, err := d.Decode(buf[:nread], pts)
+		pts++
 		if err != nil {
 			t.Error(err)
 		}
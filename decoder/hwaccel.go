@@ -0,0 +1,201 @@
+package decoder
+
+// #include <libavcodec/avcodec.h>
+import "C"
+import (
+	"errors"
+	"sync"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avcodec"
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+)
+
+// HWAccelMode selects a hardware acceleration backend used to offload
+// decoding to a GPU.
+type HWAccelMode int
+
+const (
+	// HWAccelNone disables hardware acceleration (the default).
+	HWAccelNone HWAccelMode = iota
+	HWAccelVAAPI
+	HWAccelVDPAU
+	HWAccelNVDEC
+	HWAccelQSV
+	HWAccelVideoToolbox
+)
+
+// our avutil wrapper doesn't have these AVHWDeviceType constants
+const (
+	av_HWDEVICE_TYPE_VDPAU        = 1
+	av_HWDEVICE_TYPE_CUDA         = 2
+	av_HWDEVICE_TYPE_VAAPI        = 3
+	av_HWDEVICE_TYPE_QSV          = 5
+	av_HWDEVICE_TYPE_VIDEOTOOLBOX = 6
+)
+
+func (m HWAccelMode) avType() (int, bool) {
+	switch m {
+	case HWAccelVAAPI:
+		return av_HWDEVICE_TYPE_VAAPI, true
+	case HWAccelVDPAU:
+		return av_HWDEVICE_TYPE_VDPAU, true
+	case HWAccelNVDEC:
+		return av_HWDEVICE_TYPE_CUDA, true
+	case HWAccelQSV:
+		return av_HWDEVICE_TYPE_QSV, true
+	case HWAccelVideoToolbox:
+		return av_HWDEVICE_TYPE_VIDEOTOOLBOX, true
+	default:
+		return 0, false
+	}
+}
+
+// hwPixFmt returns the AVPixelFormat a hw device of this mode decodes into,
+// e.g. AV_PIX_FMT_VAAPI for HWAccelVAAPI. get_format must pick this format
+// for avcodec to actually hand back hw frames instead of falling back to a
+// software pixel format on its own.
+func (m HWAccelMode) hwPixFmt() (C.enum_AVPixelFormat, bool) {
+	switch m {
+	case HWAccelVAAPI:
+		return C.AV_PIX_FMT_VAAPI, true
+	case HWAccelVDPAU:
+		return C.AV_PIX_FMT_VDPAU, true
+	case HWAccelNVDEC:
+		return C.AV_PIX_FMT_CUDA, true
+	case HWAccelQSV:
+		return C.AV_PIX_FMT_QSV, true
+	case HWAccelVideoToolbox:
+		return C.AV_PIX_FMT_VIDEOTOOLBOX, true
+	default:
+		return C.AV_PIX_FMT_NONE, false
+	}
+}
+
+// hwPixFmts maps a live AVCodecContext to the hw pixel format its decoder
+// was opened with. decoderGetFormat, the get_format callback, only receives
+// the raw AVCodecContext*, so it looks the wanted format up here.
+var (
+	hwPixFmtsMu sync.Mutex
+	hwPixFmts   = map[uintptr]C.enum_AVPixelFormat{}
+)
+
+// WithHWAccel enables hardware-accelerated decoding through the given
+// backend. device is the backend-specific device path (e.g.
+// "/dev/dri/renderD128" for VAAPI) and may be left empty to let the backend
+// pick its default device.
+//
+// If the hardware device cannot be created, or the decoder refuses to
+// negotiate the hw pixel format, New silently falls back to software
+// decoding rather than failing.
+func WithHWAccel(mode HWAccelMode, device string) Option {
+	return func(o *options) error {
+		o.hwAccelMode = mode
+		o.hwDevice = device
+		return nil
+	}
+}
+
+// setupHWAccel creates the hw device context for mode/device, attaches it to
+// context, and installs the get_format callback so avcodec actually
+// negotiates the hw pixel format instead of silently decoding in software.
+// It returns ok=false (without error) when hardware acceleration should be
+// skipped, so the caller can fall back to software decoding.
+func setupHWAccel(context *avcodec.Context, mode HWAccelMode, device string) (hwDeviceCtx *avutil.BufferRef, ok bool) {
+	avType, supported := mode.avType()
+	pixFmt, supported2 := mode.hwPixFmt()
+	if !supported || !supported2 {
+		return nil, false
+	}
+
+	ref := avutil.AvHwdeviceCtxCreate(avType, device, nil, 0)
+	if ref == nil {
+		return nil, false
+	}
+
+	context.SetHwDeviceCtx(ref)
+
+	key := uintptr(unsafe.Pointer(context))
+	hwPixFmtsMu.Lock()
+	hwPixFmts[key] = pixFmt
+	hwPixFmtsMu.Unlock()
+
+	context.SetGetFormat(unsafe.Pointer(C.decoderGetFormat))
+
+	return ref, true
+}
+
+// teardownHWAccel undoes setupHWAccel, used when the codec still refuses to
+// open with hw acceleration configured and New needs to retry in software.
+func teardownHWAccel(context *avcodec.Context, hwDeviceCtx *avutil.BufferRef) {
+	key := uintptr(unsafe.Pointer(context))
+	hwPixFmtsMu.Lock()
+	delete(hwPixFmts, key)
+	hwPixFmtsMu.Unlock()
+
+	context.SetGetFormat(nil)
+	context.SetHwDeviceCtx(nil)
+	if hwDeviceCtx != nil {
+		avutil.AvBufferUnref(hwDeviceCtx)
+	}
+}
+
+//export decoderGetFormat
+func decoderGetFormat(ctx *C.AVCodecContext, fmts *C.enum_AVPixelFormat) C.enum_AVPixelFormat {
+	hwPixFmtsMu.Lock()
+	want, ok := hwPixFmts[uintptr(unsafe.Pointer(ctx))]
+	hwPixFmtsMu.Unlock()
+	if !ok {
+		return *fmts
+	}
+
+	last := C.enum_AVPixelFormat(C.AV_PIX_FMT_NONE)
+	for p := fmts; *p != C.AV_PIX_FMT_NONE; p = (*C.enum_AVPixelFormat)(unsafe.Add(unsafe.Pointer(p), unsafe.Sizeof(*p))) {
+		if *p == want {
+			return *p
+		}
+		last = *p
+	}
+
+	// the hw format wasn't offered: pick the last entry, the software
+	// format, so decoding carries on in software instead of failing
+	return last
+}
+
+// transferHWFrame copies a hardware frame from GPU memory into a freshly
+// allocated software frame so it can be handed to swscale like any other
+// decoded frame.
+func transferHWFrame(hw *avutil.Frame) (*avutil.Frame, error) {
+	sw := avutil.AvFrameAlloc()
+	if sw == nil {
+		return nil, errors.New("cannot allocate frame")
+	}
+
+	if ret := avutil.AvHwframeTransferData(sw, hw, 0); ret < 0 {
+		avutil.AvFrameFree(sw)
+		return nil, errors.New("cannot transfer hw frame to cpu memory")
+	}
+
+	// av_hwframe_transfer_data only moves pixel data; key_frame, pict_type,
+	// pts and the rest of the frame's metadata have to be copied over
+	// separately or every hw-decoded Frame reports them as zero values.
+	if ret := avutil.AvFrameCopyProps(sw, hw); ret < 0 {
+		avutil.AvFrameFree(sw)
+		return nil, errors.New("cannot copy frame properties")
+	}
+
+	return sw, nil
+}
+
+// isHWFrame reports whether frame was actually decoded in mode's hw pixel
+// format. get_format can still fall back to a software format on its own
+// (e.g. no hw decoder for this stream's profile), in which case the frame
+// never has hw_frames_ctx set and must not be passed to
+// av_hwframe_transfer_data.
+func isHWFrame(frame *avutil.Frame, mode HWAccelMode) bool {
+	pixFmt, ok := mode.hwPixFmt()
+	if !ok {
+		return false
+	}
+	return avutil.Format(frame) == int(pixFmt)
+}
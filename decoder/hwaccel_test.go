@@ -0,0 +1,38 @@
+package decoder
+
+import "testing"
+
+// TestHWAccelModeMappings checks that every HWAccelMode the package exposes
+// maps to a supported AVHWDeviceType and AVPixelFormat, and that
+// HWAccelNone maps to neither (it's handled separately by New/setupHWAccel).
+func TestHWAccelModeMappings(t *testing.T) {
+	modes := []HWAccelMode{HWAccelVAAPI, HWAccelVDPAU, HWAccelNVDEC, HWAccelQSV, HWAccelVideoToolbox}
+
+	for _, m := range modes {
+		if _, ok := m.avType(); !ok {
+			t.Errorf("mode %d: avType() reported unsupported", m)
+		}
+		if _, ok := m.hwPixFmt(); !ok {
+			t.Errorf("mode %d: hwPixFmt() reported unsupported", m)
+		}
+	}
+
+	if _, ok := HWAccelNone.avType(); ok {
+		t.Error("HWAccelNone.avType() should report unsupported")
+	}
+	if _, ok := HWAccelNone.hwPixFmt(); ok {
+		t.Error("HWAccelNone.hwPixFmt() should report unsupported")
+	}
+}
+
+// TestDecoderGetFormatUnregistered checks that a context setupHWAccel never
+// registered (here the nil key 0) has no entry in hwPixFmts, the state in
+// which decoderGetFormat returns the first offered format.
+func TestDecoderGetFormatUnregistered(t *testing.T) {
+	hwPixFmtsMu.Lock()
+	_, ok := hwPixFmts[0]
+	hwPixFmtsMu.Unlock()
+	if ok {
+		t.Fatal("key 0 unexpectedly present in hwPixFmts")
+	}
+}
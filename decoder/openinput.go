@@ -0,0 +1,193 @@
+package decoder
+
+import (
+	"errors"
+	"io"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avcodec"
+	"github.com/ailumiyana/goav-incr/goav/avformat"
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+	"github.com/ailumiyana/goav-incr/goav/swscale"
+)
+
+// InputStream demuxes a file, HTTP, or RTSP/RTP URL and decodes its best
+// video stream, so callers don't have to hand-roll avformat glue on top of
+// the raw-bitstream-only Decoder.
+type InputStream struct {
+	fmtCtx    *avformat.Context
+	context   *avcodec.Context
+	streamIdx int
+	pkt       *avcodec.Packet
+	frame     *avutil.Frame
+	converter *converter
+}
+
+// OpenInput opens url (a file path, HTTP URL, or RTSP/RTP URL), picks its
+// best video stream, and pre-primes a decoder for it with the stream's
+// extradata (SPS/PPS). Use WithDemuxerOption to pass avformat options such
+// as "rtsp_transport"="tcp" for IP cameras, and WithPixelFormat to pick the
+// output pixel format (defaults to PixelFormatRGB).
+func OpenInput(url string, opts ...Option) (*InputStream, error) {
+	o, err := newOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	pxlFmt := PixelFormatRGB
+	if o.pixelFormatSet {
+		pxlFmt = o.pixelFormat
+	}
+
+	var dict *avutil.Dictionary
+	for k, v := range o.demuxerOpts {
+		avutil.AvDictSet(&dict, k, v, 0)
+	}
+
+	fmtCtx := avformat.AvformatAllocContext()
+	if fmtCtx == nil {
+		return nil, errors.New("cannot allocate format context")
+	}
+
+	if avformat.AvformatOpenInput(&fmtCtx, url, nil, &dict) != 0 {
+		return nil, errors.New("cannot open input")
+	}
+
+	if fmtCtx.AvformatFindStreamInfo(nil) < 0 {
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot find stream info")
+	}
+
+	streamIdx := fmtCtx.AvFindBestStream(avformat.AVMEDIA_TYPE_VIDEO, -1, -1, nil, 0)
+	if streamIdx < 0 {
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot find video stream")
+	}
+	codecpar := fmtCtx.Streams()[streamIdx].Codecpar()
+
+	codec := avcodec.AvcodecFindDecoder(codecpar.CodecId())
+	if codec == nil {
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot find decoder")
+	}
+
+	context := codec.AvcodecAllocContext3()
+	if context == nil {
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot allocate context")
+	}
+
+	// copies extradata (SPS/PPS) from the demuxer into context so the
+	// decoder doesn't need an in-band parser to find them
+	if avcodec.AvcodecParametersToContext(context, codecpar) < 0 {
+		avutil.AvFree(unsafe.Pointer(context))
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot copy codec parameters")
+	}
+
+	if context.AvcodecOpen2(codec, nil) < 0 {
+		avutil.AvFree(unsafe.Pointer(context))
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot open codec")
+	}
+
+	var converterPxlFmt swscale.PixelFormat
+	switch pxlFmt {
+	case PixelFormatRGB:
+		converterPxlFmt = avcodec.AV_PIX_FMT_RGB24
+	case PixelFormatBGR:
+		converterPxlFmt = av_PIX_FMT_BGR24
+	default:
+		context.AvcodecClose()
+		avutil.AvFree(unsafe.Pointer(context))
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("unsupported pixel format")
+	}
+
+	conv, err := newConverter(converterPxlFmt)
+	if err != nil {
+		context.AvcodecClose()
+		avutil.AvFree(unsafe.Pointer(context))
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, err
+	}
+
+	pkt := avcodec.AvPacketAlloc()
+	if pkt == nil {
+		conv.Close()
+		context.AvcodecClose()
+		avutil.AvFree(unsafe.Pointer(context))
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot allocate packet")
+	}
+
+	frame := avutil.AvFrameAlloc()
+	if frame == nil {
+		pkt.AvFreePacket()
+		conv.Close()
+		context.AvcodecClose()
+		avutil.AvFree(unsafe.Pointer(context))
+		avformat.AvformatCloseInput(fmtCtx)
+		return nil, errors.New("cannot allocate frame")
+	}
+
+	return &InputStream{
+		fmtCtx:    fmtCtx,
+		context:   context,
+		streamIdx: streamIdx,
+		pkt:       pkt,
+		frame:     frame,
+		converter: conv,
+	}, nil
+}
+
+// NextFrame returns the next decoded frame from the stream. It returns
+// io.EOF once the input is exhausted.
+func (s *InputStream) NextFrame() (*Frame, error) {
+	for {
+		if s.fmtCtx.AvReadFrame(s.pkt) < 0 {
+			return nil, io.EOF
+		}
+		if s.pkt.StreamIndex() != s.streamIdx {
+			s.pkt.AvFreePacket()
+			continue
+		}
+
+		gotPicture := 0
+		ret := s.context.AvcodecDecodeVideo2((*avcodec.Frame)(unsafe.Pointer(s.frame)), &gotPicture, s.pkt)
+		pts, dts := s.pkt.Pts(), s.pkt.Dts()
+		s.pkt.AvFreePacket()
+
+		if ret < 0 {
+			return nil, errors.New("error decoding frame")
+		}
+		if gotPicture == 0 {
+			continue
+		}
+
+		width, height := s.context.Width(), s.context.Height()
+		bufferSize := s.converter.PredictSize(width, height)
+		key := bufferKey{w: width, h: height, pixFmt: s.converter.pixFmt}
+		buffer := (*uint8)(getBuffer(key, bufferSize))
+		rgbframe, err := s.converter.Convert(s.context, s.frame, buffer)
+		if err != nil {
+			putBuffer(key, unsafe.Pointer(buffer))
+			return nil, err
+		}
+
+		return newFrame(s.frame, rgbframe, unsafe.Pointer(buffer), key, pts, dts), nil
+	}
+}
+
+// Close frees memory used by the InputStream and closes the underlying
+// input. It needs to be called to prevent memory leaks.
+func (s *InputStream) Close() {
+	s.converter.Close()
+
+	s.context.AvcodecClose()
+	avutil.AvFree(unsafe.Pointer(s.context))
+	avutil.AvFrameFree(s.frame)
+	s.pkt.AvFreePacket()
+
+	avformat.AvformatCloseInput(s.fmtCtx)
+}
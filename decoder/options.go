@@ -0,0 +1,49 @@
+package decoder
+
+// options holds the configuration gathered from the Option values passed to
+// New and OpenInput.
+type options struct {
+	hwAccelMode HWAccelMode
+	hwDevice    string
+
+	pixelFormat    PixelFormat
+	pixelFormatSet bool
+
+	demuxerOpts map[string]string
+}
+
+// Option configures a Decoder or InputStream at construction time.
+type Option func(*options) error
+
+// WithPixelFormat sets the output pixel format for OpenInput's decoded
+// frames. It defaults to PixelFormatRGB when not given.
+func WithPixelFormat(pxlFmt PixelFormat) Option {
+	return func(o *options) error {
+		o.pixelFormat = pxlFmt
+		o.pixelFormatSet = true
+		return nil
+	}
+}
+
+// WithDemuxerOption passes a single avformat demuxer option (e.g.
+// "rtsp_transport"="tcp", "stimeout"="5000000") to OpenInput. It may be
+// given multiple times.
+func WithDemuxerOption(key, value string) Option {
+	return func(o *options) error {
+		if o.demuxerOpts == nil {
+			o.demuxerOpts = map[string]string{}
+		}
+		o.demuxerOpts[key] = value
+		return nil
+	}
+}
+
+func newOptions(opts ...Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,26 @@
+package decoder
+
+import "github.com/ailumiyana/goav-incr/goav/avutil"
+
+// PictureType identifies the H.264/H.265 slice type of a decoded frame.
+type PictureType int
+
+const (
+	PictureTypeNone PictureType = iota
+	PictureTypeI
+	PictureTypeP
+	PictureTypeB
+)
+
+func newPictureType(pt int) PictureType {
+	switch pt {
+	case avutil.AV_PICTURE_TYPE_I:
+		return PictureTypeI
+	case avutil.AV_PICTURE_TYPE_P:
+		return PictureTypeP
+	case avutil.AV_PICTURE_TYPE_B:
+		return PictureTypeB
+	default:
+		return PictureTypeNone
+	}
+}
@@ -0,0 +1,102 @@
+package decoder_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mike1808/h264decoder/decoder"
+	"github.com/mike1808/h264decoder/encoder"
+)
+
+// encodeElementaryStream encodes numFrames solid-color frames into a raw
+// H.264 elementary bitstream (no container), suitable for feeding straight
+// into Decode/DecodeStream.
+func encodeElementaryStream(t *testing.T, numFrames int) []byte {
+	t.Helper()
+
+	const width, height, fps = 64, 64, 25
+
+	enc, err := encoder.New(encoder.H264, width, height, fps, 200000, encoder.PixelFormatRGB)
+	if err != nil {
+		t.Fatalf("encoder.New: %v", err)
+	}
+	defer enc.Close()
+
+	var buf bytes.Buffer
+	frame := make([]byte, width*height*3)
+
+	for i := 0; i < numFrames; i++ {
+		pkt, err := enc.Encode(frame)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if pkt != nil {
+			buf.Write(pkt.Data)
+		}
+	}
+	for {
+		pkt, err := enc.Flush()
+		if err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		if pkt == nil {
+			break
+		}
+		buf.Write(pkt.Data)
+	}
+
+	return buf.Bytes()
+}
+
+// TestDecodePTSEcho checks that the pts passed to Decode is the one reported
+// on the frames it yields, instead of the hardcoded constant the baseline
+// parser call used.
+func TestDecodePTSEcho(t *testing.T) {
+	stream := encodeElementaryStream(t, 3)
+
+	d, err := decoder.New(decoder.PixelFormatRGB, decoder.H264)
+	if err != nil {
+		t.Fatalf("decoder.New: %v", err)
+	}
+	defer d.Close()
+
+	const wantPTS = int64(42)
+	frames, err := d.Decode(stream, wantPTS)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	for _, f := range frames {
+		if f.PTS != wantPTS {
+			t.Errorf("got PTS %d, want %d", f.PTS, wantPTS)
+		}
+		f.Release()
+	}
+}
+
+// TestFramesAndErr checks that Frames streams at least one decoded frame and
+// ends with a nil Err, and that Err, called concurrently with the channel draining, never
+// races with the goroutine writing it (go test -race is what actually
+// proves this; the call pattern here is the one the race would require).
+func TestFramesAndErr(t *testing.T) {
+	stream := encodeElementaryStream(t, 5)
+
+	d, err := decoder.New(decoder.PixelFormatRGB, decoder.H264)
+	if err != nil {
+		t.Fatalf("decoder.New: %v", err)
+	}
+	defer d.Close()
+
+	count := 0
+	for f := range d.Frames(bytes.NewReader(stream)) {
+		_ = d.Err()
+		count++
+		f.Release()
+	}
+
+	if err := d.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if count == 0 {
+		t.Fatal("expected at least one decoded frame")
+	}
+}
@@ -0,0 +1,230 @@
+package decoder
+
+// #include <stdint.h>
+import "C"
+import (
+	"errors"
+	"io"
+	"sync"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avcodec"
+	"github.com/ailumiyana/goav-incr/goav/avformat"
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+	"github.com/ailumiyana/goav-incr/goav/swscale"
+)
+
+const thumbnailAvioBufferSize = 32 * 1024
+
+// Thumbnail opens the container at path, seeks to the nearest keyframe at or
+// before atSeconds, decodes forward until it reaches a frame at or past that
+// timestamp, converts it to pxlFmt, and returns it. It wraps the
+// demux/seek/decode plumbing that Decode otherwise leaves to the caller.
+func Thumbnail(path string, atSeconds float64, pxlFmt PixelFormat) (*Frame, error) {
+	fmtCtx := avformat.AvformatAllocContext()
+	if fmtCtx == nil {
+		return nil, errors.New("cannot allocate format context")
+	}
+
+	if avformat.AvformatOpenInput(&fmtCtx, path, nil, nil) != 0 {
+		return nil, errors.New("cannot open input")
+	}
+	defer avformat.AvformatCloseInput(fmtCtx)
+
+	return thumbnailFromContext(fmtCtx, atSeconds, pxlFmt)
+}
+
+// ThumbnailReader is the io.ReadSeeker variant of Thumbnail, for callers
+// that don't have a plain file path (e.g. content already opened, or
+// fetched into memory).
+func ThumbnailReader(r io.ReadSeeker, atSeconds float64, pxlFmt PixelFormat) (*Frame, error) {
+	fmtCtx := avformat.AvformatAllocContext()
+	if fmtCtx == nil {
+		return nil, errors.New("cannot allocate format context")
+	}
+
+	id := registerThumbnailReader(r)
+	defer unregisterThumbnailReader(id)
+
+	buffer := avutil.AvMalloc(thumbnailAvioBufferSize)
+	ioCtx := avformat.AvioAllocContext(
+		(*uint8)(buffer), thumbnailAvioBufferSize, 0,
+		unsafe.Pointer(uintptr(id)),
+		(*[0]byte)(C.thumbnailReadCallback),
+		nil,
+		(*[0]byte)(C.thumbnailSeekCallback),
+	)
+	if ioCtx == nil {
+		avutil.AvFree(buffer)
+		return nil, errors.New("cannot allocate avio context")
+	}
+	defer func() {
+		avutil.AvFree(buffer)
+		avformat.AvioContextFree(ioCtx)
+	}()
+
+	fmtCtx.SetPb(ioCtx)
+	fmtCtx.SetFlags(fmtCtx.Flags() | avformat.AVFMT_FLAG_CUSTOM_IO)
+
+	if avformat.AvformatOpenInput(&fmtCtx, "", nil, nil) != 0 {
+		return nil, errors.New("cannot open input")
+	}
+	defer avformat.AvformatCloseInput(fmtCtx)
+
+	return thumbnailFromContext(fmtCtx, atSeconds, pxlFmt)
+}
+
+// thumbnailReaders lets the C read/seek callbacks, which only receive an
+// opaque void*, reach back into the Go io.ReadSeeker they were opened with.
+var (
+	thumbnailReadersMu sync.Mutex
+	thumbnailReaders   = map[int]io.ReadSeeker{}
+	thumbnailReaderSeq int
+)
+
+func registerThumbnailReader(r io.ReadSeeker) int {
+	thumbnailReadersMu.Lock()
+	defer thumbnailReadersMu.Unlock()
+	thumbnailReaderSeq++
+	thumbnailReaders[thumbnailReaderSeq] = r
+	return thumbnailReaderSeq
+}
+
+func unregisterThumbnailReader(id int) {
+	thumbnailReadersMu.Lock()
+	delete(thumbnailReaders, id)
+	thumbnailReadersMu.Unlock()
+}
+
+//export thumbnailReadCallback
+func thumbnailReadCallback(opaque unsafe.Pointer, buf *C.uint8_t, bufSize C.int) C.int {
+	thumbnailReadersMu.Lock()
+	r := thumbnailReaders[int(uintptr(opaque))]
+	thumbnailReadersMu.Unlock()
+	if r == nil {
+		return -1
+	}
+
+	dst := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(bufSize))
+	n, err := r.Read(dst)
+	if n == 0 && err != nil {
+		return -1
+	}
+	return C.int(n)
+}
+
+//export thumbnailSeekCallback
+func thumbnailSeekCallback(opaque unsafe.Pointer, offset C.int64_t, whence C.int) C.int64_t {
+	thumbnailReadersMu.Lock()
+	r := thumbnailReaders[int(uintptr(opaque))]
+	thumbnailReadersMu.Unlock()
+	if r == nil {
+		return -1
+	}
+
+	pos, err := r.Seek(int64(offset), int(whence))
+	if err != nil {
+		return -1
+	}
+	return C.int64_t(pos)
+}
+
+// thumbnailFromContext does the demux/seek/decode work shared by Thumbnail
+// and ThumbnailReader once fmtCtx has been opened by the caller.
+func thumbnailFromContext(fmtCtx *avformat.Context, atSeconds float64, pxlFmt PixelFormat) (*Frame, error) {
+	if fmtCtx.AvformatFindStreamInfo(nil) < 0 {
+		return nil, errors.New("cannot find stream info")
+	}
+
+	streamIdx := fmtCtx.AvFindBestStream(avformat.AVMEDIA_TYPE_VIDEO, -1, -1, nil, 0)
+	if streamIdx < 0 {
+		return nil, errors.New("cannot find video stream")
+	}
+	stream := fmtCtx.Streams()[streamIdx]
+	codecpar := stream.Codecpar()
+
+	codec := avcodec.AvcodecFindDecoder(codecpar.CodecId())
+	if codec == nil {
+		return nil, errors.New("cannot find decoder")
+	}
+
+	context := codec.AvcodecAllocContext3()
+	if context == nil {
+		return nil, errors.New("cannot allocate context")
+	}
+	defer avutil.AvFree(unsafe.Pointer(context))
+
+	if avcodec.AvcodecParametersToContext(context, codecpar) < 0 {
+		return nil, errors.New("cannot copy codec parameters")
+	}
+	if context.AvcodecOpen2(codec, nil) < 0 {
+		return nil, errors.New("cannot open codec")
+	}
+	defer context.AvcodecClose()
+
+	timeBase := stream.TimeBase()
+	targetTS := int64(atSeconds * float64(timeBase.Den()) / float64(timeBase.Num()))
+
+	if fmtCtx.AvSeekFrame(streamIdx, targetTS, avformat.AVSEEK_FLAG_BACKWARD) < 0 {
+		return nil, errors.New("cannot seek to timestamp")
+	}
+
+	var converterPxlFmt swscale.PixelFormat
+	switch pxlFmt {
+	case PixelFormatRGB:
+		converterPxlFmt = avcodec.AV_PIX_FMT_RGB24
+	case PixelFormatBGR:
+		converterPxlFmt = av_PIX_FMT_BGR24
+	default:
+		return nil, errors.New("unsupported pixel format")
+	}
+
+	conv, err := newConverter(converterPxlFmt)
+	if err != nil {
+		return nil, err
+	}
+	defer conv.Close()
+
+	pkt := avcodec.AvPacketAlloc()
+	if pkt == nil {
+		return nil, errors.New("cannot allocate packet")
+	}
+	defer pkt.AvFreePacket()
+
+	frame := avutil.AvFrameAlloc()
+	if frame == nil {
+		return nil, errors.New("cannot allocate frame")
+	}
+	defer avutil.AvFrameFree(frame)
+
+	for {
+		if fmtCtx.AvReadFrame(pkt) < 0 {
+			return nil, errors.New("reached end of stream before target timestamp")
+		}
+		if pkt.StreamIndex() != streamIdx {
+			pkt.AvFreePacket()
+			continue
+		}
+
+		gotPicture := 0
+		ret := context.AvcodecDecodeVideo2((*avcodec.Frame)(unsafe.Pointer(frame)), &gotPicture, pkt)
+		pts, dts := pkt.Pts(), pkt.Dts()
+		pkt.AvFreePacket()
+
+		if ret < 0 || gotPicture == 0 || pts < targetTS {
+			continue
+		}
+
+		width, height := context.Width(), context.Height()
+		bufferSize := conv.PredictSize(width, height)
+		key := bufferKey{w: width, h: height, pixFmt: conv.pixFmt}
+		buffer := (*uint8)(getBuffer(key, bufferSize))
+		rgbframe, err := conv.Convert(context, frame, buffer)
+		if err != nil {
+			putBuffer(key, unsafe.Pointer(buffer))
+			return nil, err
+		}
+
+		return newFrame(frame, rgbframe, unsafe.Pointer(buffer), key, pts, dts), nil
+	}
+}
@@ -0,0 +1,72 @@
+package encoder
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avcodec"
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+	"github.com/ailumiyana/goav-incr/goav/swscale"
+)
+
+// converter converts packed RGB/BGR input frames into the YUV420P frames the
+// encoder expects, the mirror image of the decoder package's converter.
+type converter struct {
+	context       *swscale.Context
+	srcFrame      *avutil.Frame
+	pixFmt        swscale.PixelFormat
+	width, height int
+}
+
+func newConverter(pixelFormat swscale.PixelFormat, width, height int) (*converter, error) {
+	srcFrame := avutil.AvFrameAlloc()
+	if srcFrame == nil {
+		return nil, errors.New("cannot allocate frame")
+	}
+
+	swsCtx := swscale.SwsGetcontext(
+		width, height, pixelFormat,
+		width, height, avcodec.AV_PIX_FMT_YUV420P,
+		avcodec.SWS_BILINEAR,
+		nil, nil, nil,
+	)
+	if swsCtx == nil {
+		return nil, errors.New("cannot allocate sws context")
+	}
+
+	return &converter{
+		context:  swsCtx,
+		srcFrame: srcFrame,
+		pixFmt:   pixelFormat,
+		width:    width,
+		height:   height,
+	}, nil
+}
+
+// PredictSize returns the number of bytes Convert expects data to hold for
+// c.width x c.height in c.pixFmt.
+func (c *converter) PredictSize() int {
+	avp := (*avcodec.Picture)(unsafe.Pointer(c.srcFrame))
+	return avp.AvpictureFill(nil, (avcodec.PixelFormat)(c.pixFmt), c.width, c.height)
+}
+
+// Convert scales/converts a packed RGB/BGR buffer into dst, a YUV420P frame
+// already sized for c.width x c.height.
+func (c *converter) Convert(data []byte, dst *avutil.Frame) error {
+	if want := c.PredictSize(); len(data) < want {
+		return errors.New("input buffer too small for frame size")
+	}
+
+	avp := (*avcodec.Picture)(unsafe.Pointer(c.srcFrame))
+	avp.AvpictureFill((*uint8)(unsafe.Pointer(&data[0])), (avcodec.PixelFormat)(c.pixFmt), c.width, c.height)
+
+	swscale.SwsScale2(c.context, avutil.Data(c.srcFrame), avutil.Linesize(c.srcFrame), 0, c.height,
+		avutil.Data(dst), avutil.Linesize(dst))
+
+	return nil
+}
+
+func (c *converter) Close() {
+	swscale.SwsFreecontext(c.context)
+	avutil.AvFrameFree(c.srcFrame)
+}
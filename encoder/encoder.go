@@ -0,0 +1,183 @@
+package encoder
+
+import "C"
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avcodec"
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+	"github.com/ailumiyana/goav-incr/goav/swscale"
+)
+
+type PixelFormat int
+
+const (
+	PixelFormatRGB = avcodec.AV_PIX_FMT_RGB24
+	PixelFormatBGR = av_PIX_FMT_BGR24
+)
+
+// our avcodec wrapper doesn't have this constant
+const av_PIX_FMT_BGR24 = 3
+
+type Compression int
+
+const (
+	H264 = Compression(avcodec.AV_CODEC_ID_H264)
+	H265 = Compression(avcodec.AV_CODEC_ID_H265)
+)
+
+// Encoder compresses RGB/BGR frames into an H.264/H.265 bitstream
+type Encoder struct {
+	context   *avcodec.Context
+	frame     *avutil.Frame
+	pkt       *avcodec.Packet
+	converter *converter
+
+	width, height, fps int
+	pts                int64
+}
+
+// Packet is one encoded access unit, along with the timing and keyframe
+// information a muxer needs to place it correctly in a container.
+type Packet struct {
+	Data     []byte
+	PTS, DTS int64
+	KeyFrame bool
+}
+
+// New creates a new Encoder that encodes width x height pxlFmt frames at fps
+// into a cpr bitstream targeting bitrate bits/sec.
+func New(cpr Compression, width, height, fps, bitrate int, pxlFmt PixelFormat) (*Encoder, error) {
+	avcodec.AvcodecRegisterAll()
+	codec := avcodec.AvcodecFindEncoder(avcodec.CodecId(cpr))
+	if codec == nil {
+		return nil, errors.New("cannot find encoder")
+	}
+	context := codec.AvcodecAllocContext3()
+	if context == nil {
+		return nil, errors.New("cannot allocate context")
+	}
+
+	context.SetWidth(width)
+	context.SetHeight(height)
+	context.SetTimeBase(1, fps)
+	context.SetBitRate(int64(bitrate))
+	context.SetGopSize(fps)
+	context.SetPixFmt(avcodec.AV_PIX_FMT_YUV420P)
+
+	// Muxer copies these codec parameters into the container stream before
+	// avformat_write_header, so SPS/PPS need to live in extradata by the
+	// time AvcodecOpen2 returns. Without this flag libx264 only repeats
+	// them in-band per keyframe and extradata stays empty.
+	context.SetFlags(context.Flags() | avcodec.AV_CODEC_FLAG_GLOBAL_HEADER)
+
+	if cpr != H264 && cpr != H265 {
+		return nil, errors.New("unsupported compression")
+	}
+
+	if context.AvcodecOpen2(codec, nil) < 0 {
+		return nil, errors.New("cannot open encoder")
+	}
+
+	frame := avutil.AvFrameAlloc()
+	if frame == nil {
+		return nil, errors.New("cannot allocate frame")
+	}
+	if err := avutil.AvSetFrame(frame, width, height, avcodec.AV_PIX_FMT_YUV420P); err != nil {
+		return nil, err
+	}
+
+	pkt := avcodec.AvPacketAlloc()
+	if pkt == nil {
+		return nil, errors.New("cannot allocate packet")
+	}
+
+	var converterPxlFmt swscale.PixelFormat
+	switch pxlFmt {
+	case PixelFormatRGB:
+		converterPxlFmt = avcodec.AV_PIX_FMT_RGB24
+	case PixelFormatBGR:
+		converterPxlFmt = av_PIX_FMT_BGR24
+	default:
+		return nil, errors.New("unsupported pixel format")
+	}
+
+	conv, err := newConverter(converterPxlFmt, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encoder{
+		context:   context,
+		frame:     frame,
+		pkt:       pkt,
+		converter: conv,
+		width:     width,
+		height:    height,
+		fps:       fps,
+	}, nil
+}
+
+// Encode converts data, a packed width x height buffer in the pixel format
+// passed to New, to YUV420P and encodes it. The frame's PTS is assigned from
+// a monotonic counter so callers don't need to track presentation time
+// themselves. It returns a nil Packet when the encoder buffers the frame
+// internally without emitting one yet, which is normal with B-frames.
+func (e *Encoder) Encode(data []byte) (*Packet, error) {
+	if err := e.converter.Convert(data, e.frame); err != nil {
+		return nil, err
+	}
+
+	e.frame.SetPts(e.pts)
+	e.pts++
+
+	return e.encode(e.frame)
+}
+
+// Flush drains frames buffered inside the encoder. Call it once encoding is
+// done and keep calling it until it returns a nil Packet.
+func (e *Encoder) Flush() (*Packet, error) {
+	return e.encode(nil)
+}
+
+func (e *Encoder) encode(frame *avutil.Frame) (*Packet, error) {
+	gotPacket := 0
+	e.pkt.AvInitPacket()
+
+	var cFrame *avcodec.Frame
+	if frame != nil {
+		cFrame = (*avcodec.Frame)(unsafe.Pointer(frame))
+	}
+
+	ret := e.context.AvcodecEncodeVideo2(cFrame, e.pkt, &gotPacket)
+	if ret < 0 {
+		return nil, errors.New("error encoding frame")
+	}
+	if gotPacket == 0 {
+		return nil, nil
+	}
+	defer e.pkt.AvFreePacket()
+
+	return &Packet{
+		Data:     packetData(e.pkt),
+		PTS:      e.pkt.Pts(),
+		DTS:      e.pkt.Dts(),
+		KeyFrame: e.pkt.Flags()&avcodec.AV_PKT_FLAG_KEY != 0,
+	}, nil
+}
+
+// Close frees memory used by the encoder structures. It needs to be called
+// to prevent memory leaks.
+func (e *Encoder) Close() {
+	e.converter.Close()
+
+	e.context.AvcodecClose()
+	avutil.AvFree(unsafe.Pointer(e.context))
+	avutil.AvFrameFree(e.frame)
+	e.pkt.AvFreePacket()
+}
+
+func packetData(pkt *avcodec.Packet) []byte {
+	return C.GoBytes(unsafe.Pointer(pkt.Data()), C.int(pkt.Size()))
+}
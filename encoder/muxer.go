@@ -0,0 +1,183 @@
+package encoder
+
+// #include <stdint.h>
+import "C"
+import (
+	"errors"
+	"io"
+	"sync"
+	"unsafe"
+
+	"github.com/ailumiyana/goav-incr/goav/avcodec"
+	"github.com/ailumiyana/goav-incr/goav/avformat"
+	"github.com/ailumiyana/goav-incr/goav/avutil"
+)
+
+// ContainerFormat selects the muxer used to wrap an encoded H.264/H.265
+// stream.
+type ContainerFormat string
+
+const (
+	ContainerMP4  ContainerFormat = "mp4"
+	ContainerMKV  ContainerFormat = "matroska"
+	ContainerFMP4 ContainerFormat = "fmp4"
+)
+
+const avioBufferSize = 32 * 1024
+
+// Muxer writes an encoded H.264/H.265 stream into an MP4, Matroska, or
+// fragmented-MP4 container on top of an arbitrary io.Writer, via a custom
+// AVIO buffer.
+type Muxer struct {
+	fmtCtx     *avformat.Context
+	stream     *avformat.Stream
+	ioCtx      *avformat.AvIOContext
+	ioBuffer   unsafe.Pointer
+	writerID   int
+	headerDone bool
+}
+
+// muxerWriters lets the C write callback, which only receives an opaque
+// void*, reach back into the Go io.Writer it was opened with.
+var (
+	muxerWritersMu sync.Mutex
+	muxerWriters   = map[int]io.Writer{}
+	muxerWriterSeq int
+)
+
+// NewMuxer opens a Muxer that writes a format-container stream fed by enc
+// into w. Call WritePacket with every Packet enc.Encode/enc.Flush returns,
+// in the order they're returned, then Close to finalize the container.
+//
+// enc's codec parameters, extradata (SPS/PPS) included, are copied into the
+// stream right here rather than deferred until the first WritePacket. Doing
+// this after avformat_write_header is what produces the "green frames"
+// ecosystem issues trace back to: players need SPS/PPS in the stsd/avcC box
+// from the header onward, not patched in after the fact.
+func NewMuxer(format ContainerFormat, w io.Writer, enc *Encoder) (*Muxer, error) {
+	formatName := string(format)
+	if format == ContainerFMP4 {
+		formatName = "mp4"
+	}
+
+	outFmt := avformat.AvGuessFormat(formatName, "", "")
+	if outFmt == nil {
+		return nil, errors.New("cannot find output format")
+	}
+
+	fmtCtx := avformat.AvformatAllocContext()
+	if fmtCtx == nil {
+		return nil, errors.New("cannot allocate format context")
+	}
+	fmtCtx.SetOformat(outFmt)
+
+	if format == ContainerFMP4 {
+		avformat.AvDictSet(fmtCtx.Metadata(), "movflags", "frag_keyframe+empty_moov", 0)
+	}
+
+	stream := fmtCtx.AvformatNewStream(nil)
+	if stream == nil {
+		return nil, errors.New("cannot create stream")
+	}
+
+	if avcodec.AvcodecParametersFromContext(stream.Codecpar(), enc.context) < 0 {
+		return nil, errors.New("cannot copy codec parameters")
+	}
+	stream.SetTimeBase(1, enc.fps)
+
+	m := &Muxer{fmtCtx: fmtCtx, stream: stream}
+
+	muxerWritersMu.Lock()
+	muxerWriterSeq++
+	m.writerID = muxerWriterSeq
+	muxerWriters[m.writerID] = w
+	muxerWritersMu.Unlock()
+
+	m.ioBuffer = avutil.AvMalloc(avioBufferSize)
+	ioCtx := avformat.AvioAllocContext(
+		(*uint8)(m.ioBuffer), avioBufferSize, 1,
+		unsafe.Pointer(uintptr(m.writerID)),
+		nil,
+		(*[0]byte)(C.muxerWriteCallback),
+		nil,
+	)
+	if ioCtx == nil {
+		return nil, errors.New("cannot allocate avio context")
+	}
+	m.ioCtx = ioCtx
+	fmtCtx.SetPb(ioCtx)
+
+	return m, nil
+}
+
+//export muxerWriteCallback
+func muxerWriteCallback(opaque unsafe.Pointer, buf *C.uint8_t, bufSize C.int) C.int {
+	id := int(uintptr(opaque))
+
+	muxerWritersMu.Lock()
+	w := muxerWriters[id]
+	muxerWritersMu.Unlock()
+
+	if w == nil {
+		return -1
+	}
+
+	data := C.GoBytes(unsafe.Pointer(buf), bufSize)
+	n, err := w.Write(data)
+	if err != nil {
+		return -1
+	}
+
+	return C.int(n)
+}
+
+// WritePacket writes one Packet, as returned by Encoder.Encode/Encoder.Flush,
+// into the container, in its own PTS/DTS and with its own keyframe flag
+// rather than values the caller has to reconstruct.
+func (m *Muxer) WritePacket(p *Packet) error {
+	if !m.headerDone {
+		if ret := m.fmtCtx.AvformatWriteHeader(nil); ret < 0 {
+			return errors.New("cannot write header")
+		}
+		m.headerDone = true
+	}
+
+	pkt := avformat.AvPacketAlloc()
+	defer avformat.AvPacketFree(pkt)
+
+	pkt.SetData(p.Data)
+	pkt.SetPts(p.PTS)
+	pkt.SetDts(p.DTS)
+	pkt.SetStreamIndex(m.stream.Index())
+	if p.KeyFrame {
+		pkt.SetFlags(pkt.Flags() | avformat.AV_PKT_FLAG_KEY)
+	}
+
+	if ret := m.fmtCtx.AvInterleavedWriteFrame(pkt); ret < 0 {
+		return errors.New("cannot write frame")
+	}
+
+	return nil
+}
+
+// Close flushes and finalizes the container, and releases the Muxer.
+func (m *Muxer) Close() error {
+	defer func() {
+		muxerWritersMu.Lock()
+		delete(muxerWriters, m.writerID)
+		muxerWritersMu.Unlock()
+	}()
+
+	var err error
+	if m.headerDone {
+		if ret := m.fmtCtx.AvWriteTrailer(); ret < 0 {
+			err = errors.New("cannot write trailer")
+		}
+	}
+
+	avutil.AvFree(m.ioBuffer)
+	avformat.AvioContextFree(m.ioCtx)
+	avformat.AvformatFreeContext(m.fmtCtx)
+
+	return err
+}
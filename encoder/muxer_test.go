@@ -0,0 +1,114 @@
+package encoder_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mike1808/h264decoder/decoder"
+	"github.com/mike1808/h264decoder/encoder"
+)
+
+const fixtureWidth, fixtureHeight, fixtureFPS = 64, 64, 25
+
+// buildFixtureMP4 encodes numFrames solid-color frames into a temporary MP4
+// file and returns its path; the file is removed when the test ends.
+func buildFixtureMP4(t *testing.T, numFrames int) string {
+	t.Helper()
+
+	enc, err := encoder.New(encoder.H264, fixtureWidth, fixtureHeight, fixtureFPS, 200000, encoder.PixelFormatRGB)
+	if err != nil {
+		t.Fatalf("encoder.New: %v", err)
+	}
+	defer enc.Close()
+
+	out, err := os.CreateTemp("", "fixture_*.mp4")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(out.Name()) })
+	defer out.Close()
+
+	mux, err := encoder.NewMuxer(encoder.ContainerMP4, out, enc)
+	if err != nil {
+		t.Fatalf("NewMuxer: %v", err)
+	}
+
+	frame := make([]byte, fixtureWidth*fixtureHeight*3)
+
+	for i := 0; i < numFrames; i++ {
+		pkt, err := enc.Encode(frame)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if pkt != nil {
+			if err := mux.WritePacket(pkt); err != nil {
+				t.Fatalf("WritePacket: %v", err)
+			}
+		}
+	}
+	for {
+		pkt, err := enc.Flush()
+		if err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+		if pkt == nil {
+			break
+		}
+		if err := mux.WritePacket(pkt); err != nil {
+			t.Fatalf("WritePacket (flush): %v", err)
+		}
+	}
+
+	if err := mux.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	return out.Name()
+}
+
+// TestMuxerRoundTrip encodes a handful of solid-color frames into an MP4
+// file and demuxes/decodes them back, which is what actually exercises
+// NewMuxer's codec-parameter copy: if the SPS/PPS extradata isn't in the
+// container before avformat_write_header, OpenInput's decoder never
+// produces a frame.
+func TestMuxerRoundTrip(t *testing.T) {
+	path := buildFixtureMP4(t, 5)
+
+	in, err := decoder.OpenInput(path)
+	if err != nil {
+		t.Fatalf("OpenInput: %v", err)
+	}
+	defer in.Close()
+
+	decoded, err := in.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if decoded.Width != fixtureWidth || decoded.Height != fixtureHeight {
+		t.Fatalf("got frame %dx%d, want %dx%d", decoded.Width, decoded.Height, fixtureWidth, fixtureHeight)
+	}
+}
+
+// TestOpenInputMissingFile checks that OpenInput reports an error instead of
+// panicking or leaking when the source doesn't exist.
+func TestOpenInputMissingFile(t *testing.T) {
+	if _, err := decoder.OpenInput("./does-not-exist.mp4"); err == nil {
+		t.Fatal("expected an error opening a nonexistent file")
+	}
+}
+
+// TestThumbnailRoundTrip checks that Thumbnail can seek into and decode a
+// frame from a container produced by Encoder/Muxer.
+func TestThumbnailRoundTrip(t *testing.T) {
+	path := buildFixtureMP4(t, 10)
+
+	frame, err := decoder.Thumbnail(path, 0, decoder.PixelFormatRGB)
+	if err != nil {
+		t.Fatalf("Thumbnail: %v", err)
+	}
+	defer frame.Release()
+
+	if frame.Width != fixtureWidth || frame.Height != fixtureHeight {
+		t.Fatalf("got frame %dx%d, want %dx%d", frame.Width, frame.Height, fixtureWidth, fixtureHeight)
+	}
+}